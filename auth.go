@@ -0,0 +1,271 @@
+package main
+
+/*
+	Imports
+*/
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pquerna/otp/totp"
+)
+
+/*
+	Constants
+*/
+
+const (
+	accessTokenName  = "isaac.jwt"
+	refreshTokenName = "isaac.refresh"
+	accessTokenTTL   = 15 * time.Minute
+	refreshTokenTTL  = 30 * 24 * time.Hour
+
+	// An admin's TOTP claim must have been verified within this long ago or
+	// else admin* commands are rejected, even if their JWT is still valid
+	totpClaimMaxAge = 12 * time.Hour
+)
+
+/*
+	Variables
+*/
+
+var jwtSecret []byte
+
+// authInit reads the JWT signing secret from the environment. It replaces
+// the old sessionStore setup in main(), which only kept a session alive for
+// 5 seconds.
+func authInit() {
+	jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+	if len(jwtSecret) == 0 {
+		log.Fatal("JWT_SECRET is blank in the .env file.")
+	}
+}
+
+/*
+	JWT claims
+*/
+
+// AuthClaims is embedded in every access token this server issues
+type AuthClaims struct {
+	UserID int   `json:"userID"`
+	Admin  bool  `json:"admin"`
+	TOTPAt int64 `json:"totpAt"` // Unix time the admin last completed a TOTP challenge; 0 if never
+	jwt.StandardClaims
+}
+
+// issueAccessToken signs a short-lived JWT for the given user. totpAt should
+// be the current time for an admin who just passed their TOTP challenge, or
+// zero for a non-admin (who never needs one).
+func issueAccessToken(userID int, admin bool, totpAt time.Time) (string, error) {
+	claims := AuthClaims{
+		UserID: userID,
+		Admin:  admin,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(accessTokenTTL).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+	if !totpAt.IsZero() {
+		claims.TOTPAt = totpAt.Unix()
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
+
+// parseAccessToken validates a JWT and returns its claims
+func parseAccessToken(tokenString string) (*AuthClaims, error) {
+	claims := &AuthClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return jwtSecret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// totpStillFresh reports whether an admin's last TOTP challenge is recent
+// enough to authorize an admin* command
+func (c *AuthClaims) totpStillFresh() bool {
+	if c.TOTPAt == 0 {
+		return false
+	}
+	return time.Since(time.Unix(c.TOTPAt, 0)) < totpClaimMaxAge
+}
+
+/*
+	Login / refresh cookie plumbing
+
+	completeLogin is called at the end of the Auth0 callback (in loginHandler,
+	which lives outside of this snapshot) once the user's identity is known.
+	It issues the access token and sets the httpOnly refresh cookie.
+*/
+
+func completeLogin(w http.ResponseWriter, userID int, admin bool, totpAt time.Time) (string, error) {
+	accessToken, err := issueAccessToken(userID, admin, totpAt)
+	if err != nil {
+		return "", err
+	}
+
+	refreshToken, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	if err := db.Users.SetRefreshToken(userID, refreshToken); err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenName,
+		Value:    refreshToken,
+		Domain:   domain,
+		Path:     "/",
+		MaxAge:   int(refreshTokenTTL.Seconds()),
+		Secure:   useSSL,
+		HttpOnly: true,
+	})
+
+	return accessToken, nil
+}
+
+func generateOpaqueToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+/*
+	App passwords
+
+	Third-party tools (e.g. race trackers) authenticate with a named,
+	command-scoped token instead of a full login session.
+*/
+
+// profileGenerateAppPassword creates a new app password scoped to the given
+// commands, e.g. {"name": "my race tracker", "scopes": ["raceItem", "raceFloor"]}
+func profileGenerateAppPassword(c *ExtendedConnection, data string) {
+	var d struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		log.Error("Failed to unmarshal the data from a \"profileGenerateAppPassword\" command:", err)
+		return
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		log.Error("Failed to generate an app password token:", err)
+		return
+	}
+
+	if err := db.AppPasswords.Create(c.UserID, d.Name, token, d.Scopes); err != nil {
+		log.Error("Failed to save the app password:", err)
+		return
+	}
+
+	c.Emit("profileGenerateAppPassword", map[string]interface{}{
+		"name":  d.Name,
+		"token": token,
+	})
+}
+
+// profileRevokeAppPassword deletes a previously-generated app password, e.g.
+// {"name": "my race tracker"}
+func profileRevokeAppPassword(c *ExtendedConnection, data string) {
+	var d struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		log.Error("Failed to unmarshal the data from a \"profileRevokeAppPassword\" command:", err)
+		return
+	}
+
+	if err := db.AppPasswords.Revoke(c.UserID, d.Name); err != nil {
+		log.Error("Failed to revoke the app password:", err)
+	}
+}
+
+/*
+	TOTP (admins only)
+*/
+
+// profileEnableTOTP generates a new TOTP secret for the caller and returns
+// its provisioning URI so that it can be rendered as a QR code
+func profileEnableTOTP(c *ExtendedConnection, data string) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      domain,
+		AccountName: c.Username,
+	})
+	if err != nil {
+		log.Error("Failed to generate a TOTP secret:", err)
+		return
+	}
+
+	if err := db.Users.SetTOTPSecret(c.UserID, key.Secret()); err != nil {
+		log.Error("Failed to save the TOTP secret:", err)
+		return
+	}
+
+	c.Emit("profileEnableTOTP", map[string]interface{}{
+		"provisioningURI": key.URL(),
+	})
+}
+
+// profileDisableTOTP removes the caller's TOTP secret, requiring a currently
+// valid code so that a hijacked session cannot silently disable 2FA
+func profileDisableTOTP(c *ExtendedConnection, data string) {
+	var d struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		log.Error("Failed to unmarshal the data from a \"profileDisableTOTP\" command:", err)
+		return
+	}
+
+	secret, err := db.Users.GetTOTPSecret(c.UserID)
+	if err != nil {
+		log.Error("Failed to get the TOTP secret for user "+c.Username+":", err)
+		return
+	}
+
+	if !totp.Validate(d.Code, secret) {
+		c.Emit("error", "The provided TOTP code is incorrect.")
+		return
+	}
+
+	if err := db.Users.ClearTOTPSecret(c.UserID); err != nil {
+		log.Error("Failed to clear the TOTP secret:", err)
+	}
+}
+
+/*
+	Admin middleware
+
+	requireFreshTOTP wraps an admin* command so that it is rejected unless the
+	caller is an admin whose TOTP claim (set at login after they passed their
+	second factor) is still within totpClaimMaxAge.
+*/
+
+func requireFreshTOTP(handler func(*ExtendedConnection, string)) func(*ExtendedConnection, string) {
+	return func(c *ExtendedConnection, data string) {
+		if !c.Admin || !c.AuthClaims.totpStillFresh() {
+			c.Emit("error", "You must re-authenticate with your TOTP code to use admin commands.")
+			return
+		}
+		handler(c, data)
+	}
+}
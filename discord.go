@@ -0,0 +1,268 @@
+package main
+
+/*
+	Imports
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"golang.org/x/time/rate"
+)
+
+/*
+	Constants
+*/
+
+const (
+	// Outbound posts are limited to this many messages per this many seconds,
+	// since Discord will temporarily ban bots that post too quickly
+	discordRateLimitRate = 5
+	discordRateLimitPer  = time.Second * 2
+)
+
+/*
+	Variables
+*/
+
+var (
+	discordSession *discordgo.Session
+	discordLimiter = rate.NewLimiter(rate.Every(discordRateLimitPer/discordRateLimitRate), discordRateLimitRate)
+
+	// The channel that race lifecycle events (race created, started, finishes, ended) are posted to
+	discordAnnouncementsChannelID string
+
+	// Maps a Golem chat room name to a Discord channel ID and back again;
+	// populated from the DISCORD_ROOM_MAP environment variable and mutable at
+	// runtime via the "adminDiscordLink" / "adminDiscordUnlink" commands
+	discordRoomMap = struct {
+		sync.RWMutex
+		roomToChannel map[string]string
+		channelToRoom map[string]string
+	}{
+		roomToChannel: make(map[string]string),
+		channelToRoom: make(map[string]string),
+	}
+)
+
+/*
+	Initialization
+*/
+
+// discordInit connects the Discord bot and wires up the chat room <--> channel
+// bridge. Like twitchInit(), it is meant to be run in a new goroutine from
+// main() so that a failure to connect does not prevent the server from
+// starting up.
+func discordInit() {
+	token := os.Getenv("DISCORD_BOT_TOKEN")
+	if token == "" {
+		log.Info("DISCORD_BOT_TOKEN is blank in the .env file; the Discord bridge will not be initialized.")
+		return
+	}
+
+	discordAnnouncementsChannelID = os.Getenv("DISCORD_ANNOUNCEMENTS_CHANNEL_ID")
+	discordLoadRoomMap(os.Getenv("DISCORD_ROOM_MAP"))
+
+	session, err := discordgo.New("Bot " + token)
+	if err != nil {
+		log.Error("Failed to create the Discord session:", err)
+		return
+	}
+
+	session.AddHandler(discordMessageCreate)
+
+	if err := session.Open(); err != nil {
+		log.Error("Failed to open the Discord session:", err)
+		return
+	}
+	discordSession = session
+
+	log.Info("Discord bridge initialized.")
+}
+
+// discordLoadRoomMap parses the DISCORD_ROOM_MAP environment variable, which
+// is a comma-separated list of "room:channelID" pairs,
+// e.g. "general:123456789012345678,bingo:234567890123456789"
+func discordLoadRoomMap(envVar string) {
+	if envVar == "" {
+		return
+	}
+
+	discordRoomMap.Lock()
+	defer discordRoomMap.Unlock()
+
+	for _, pair := range strings.Split(envVar, ",") {
+		halves := strings.SplitN(pair, ":", 2)
+		if len(halves) != 2 {
+			log.Error("Failed to parse the \"" + pair + "\" entry in the DISCORD_ROOM_MAP environment variable.")
+			continue
+		}
+		room := halves[0]
+		channelID := halves[1]
+		discordRoomMap.roomToChannel[room] = channelID
+		discordRoomMap.channelToRoom[channelID] = room
+	}
+}
+
+/*
+	Room <--> channel linking (used by the admin commands and the bridge;
+	discordLoadRoomMap fills the same maps directly)
+*/
+
+func discordLinkRoom(room string, channelID string) {
+	discordRoomMap.Lock()
+	defer discordRoomMap.Unlock()
+
+	discordRoomMap.roomToChannel[room] = channelID
+	discordRoomMap.channelToRoom[channelID] = room
+}
+
+func discordUnlinkRoom(room string) {
+	discordRoomMap.Lock()
+	defer discordRoomMap.Unlock()
+
+	if channelID, ok := discordRoomMap.roomToChannel[room]; ok {
+		delete(discordRoomMap.channelToRoom, channelID)
+	}
+	delete(discordRoomMap.roomToChannel, room)
+}
+
+func discordChannelForRoom(room string) (string, bool) {
+	discordRoomMap.RLock()
+	defer discordRoomMap.RUnlock()
+
+	channelID, ok := discordRoomMap.roomToChannel[room]
+	return channelID, ok
+}
+
+func discordRoomForChannel(channelID string) (string, bool) {
+	discordRoomMap.RLock()
+	defer discordRoomMap.RUnlock()
+
+	room, ok := discordRoomMap.channelToRoom[channelID]
+	return room, ok
+}
+
+/*
+	Discord --> server
+*/
+
+// discordMessageCreate mirrors a message posted in a linked Discord channel
+// into the corresponding Golem chat room
+func discordMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Author.Bot {
+		return
+	}
+
+	room, ok := discordRoomForChannel(m.ChannelID)
+	if !ok {
+		return
+	}
+
+	roomManager.Broadcast(room, "roomMessage", map[string]interface{}{
+		"room":    room,
+		"name":    "Discord: " + m.Author.Username,
+		"message": m.Content,
+	})
+}
+
+/*
+	Server --> Discord
+*/
+
+// discordRelayChatMessage is called from the "roomMessage" command handler so
+// that a message sent in a Golem chat room is mirrored into the linked
+// Discord channel, if any
+func discordRelayChatMessage(room string, username string, message string) {
+	channelID, ok := discordChannelForRoom(room)
+	if !ok {
+		return
+	}
+
+	discordPost(channelID, fmt.Sprintf("**%v:** %v", username, message))
+}
+
+// discordAnnounceRaceCreated posts to the announcements channel when a new race is opened
+func discordAnnounceRaceCreated(raceID int, name string, ruleset string) {
+	discordAnnounce(fmt.Sprintf("Race #%v created: \"%v\" (%v)", raceID, name, ruleset))
+}
+
+// discordAnnounceRaceStarted posts to the announcements channel when a race begins
+func discordAnnounceRaceStarted(raceID int) {
+	discordAnnounce(fmt.Sprintf("Race #%v has started.", raceID))
+}
+
+// discordAnnounceRacerFinished posts to the announcements channel each time a
+// racer finishes, including their place and time
+func discordAnnounceRacerFinished(raceID int, username string, place int, elapsedTime time.Duration) {
+	discordAnnounce(fmt.Sprintf("Race #%v: %v finished in place %v (%v).", raceID, username, place, elapsedTime.Round(time.Second)))
+}
+
+// discordAnnounceRaceEnded posts to the announcements channel when a race finishes
+func discordAnnounceRaceEnded(raceID int) {
+	discordAnnounce(fmt.Sprintf("Race #%v has ended.", raceID))
+}
+
+func discordAnnounce(message string) {
+	if discordAnnouncementsChannelID == "" {
+		return
+	}
+	discordPost(discordAnnouncementsChannelID, message)
+}
+
+// discordPost sends a message to a Discord channel, respecting the outbound rate limit
+func discordPost(channelID string, message string) {
+	if discordSession == nil {
+		return
+	}
+
+	if err := discordLimiter.Wait(context.Background()); err != nil {
+		log.Error("Failed to wait for the Discord rate limiter:", err)
+		return
+	}
+
+	if _, err := discordSession.ChannelMessageSend(channelID, message); err != nil {
+		log.Error("Failed to post a message to Discord channel \""+channelID+"\":", err)
+	}
+}
+
+/*
+	Admin commands
+*/
+
+// adminDiscordLink binds a Golem chat room to a Discord channel at runtime,
+// e.g. {"room": "general", "channelID": "123456789012345678"}
+func adminDiscordLink(c *ExtendedConnection, data string) {
+	var d struct {
+		Room      string `json:"room"`
+		ChannelID string `json:"channelID"`
+	}
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		log.Error("Failed to unmarshal the data from an \"adminDiscordLink\" command:", err)
+		return
+	}
+
+	discordLinkRoom(d.Room, d.ChannelID)
+	log.Info("Admin linked room \"" + d.Room + "\" to Discord channel \"" + d.ChannelID + "\".")
+}
+
+// adminDiscordUnlink removes a room <--> channel binding, e.g. {"room": "general"}
+func adminDiscordUnlink(c *ExtendedConnection, data string) {
+	var d struct {
+		Room string `json:"room"`
+	}
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		log.Error("Failed to unmarshal the data from an \"adminDiscordUnlink\" command:", err)
+		return
+	}
+
+	discordUnlinkRoom(d.Room)
+	log.Info("Admin unlinked room \"" + d.Room + "\" from Discord.")
+}
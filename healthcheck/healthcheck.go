@@ -0,0 +1,73 @@
+// Package healthcheck provides the "/healthz" (liveness) and "/readyz"
+// (readiness) HTTP handlers, plus the "/metrics" Prometheus exposition
+// handler, that main.go registers alongside the existing Pat routes.
+package healthcheck
+
+/*
+	Imports
+*/
+
+import (
+	"net/http"
+	"sync"
+)
+
+// Checker runs a set of named readiness checks (e.g. "database is reachable",
+// "the Twitch bot is connected") and is safe for concurrent use
+type Checker struct {
+	mu       sync.RWMutex
+	checks   map[string]func() error
+	draining bool // Set by ShutdownStarted() so /readyz fails fast during a graceful shutdown
+}
+
+// New creates an empty Checker; call Register() to add checks to it
+func New() *Checker {
+	return &Checker{
+		checks: make(map[string]func() error),
+	}
+}
+
+// Register adds a named readiness check. It is typically called once per
+// subsystem at startup (database, Golem router).
+func (c *Checker) Register(name string, check func() error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// ShutdownStarted marks the service as not ready, so that a load balancer
+// stops routing new traffic to it while it drains in-flight connections
+func (c *Checker) ShutdownStarted() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.draining = true
+}
+
+// Liveness reports whether the process itself is up. It does not run any of
+// the registered checks, since a dependency outage should not cause the
+// orchestrator to restart an otherwise-healthy process.
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// Readiness reports whether the service should currently receive traffic
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.draining {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	for name, check := range c.checks {
+		if err := check(); err != nil {
+			http.Error(w, name+": "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
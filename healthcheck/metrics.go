@@ -0,0 +1,85 @@
+package healthcheck
+
+/*
+	Imports
+*/
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/*
+	Prometheus metrics
+
+	These are registered on the default registry at package init time and
+	updated by main.go (connected clients, rooms/users) and by the command
+	dispatcher (commands processed, command latency).
+*/
+
+var (
+	// ConnectedClients is the number of currently-open WebSocket connections,
+	// mirroring the size of connectionMap
+	ConnectedClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "isaac_connected_clients",
+		Help: "Number of currently-connected WebSocket clients.",
+	})
+
+	// ChatRooms and ChatUsers mirror the size of chatRoomMap
+	ChatRooms = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "isaac_chat_rooms",
+		Help: "Number of currently-open chat rooms.",
+	})
+	ChatUsers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "isaac_chat_users",
+		Help: "Number of chat room memberships, summed over all rooms (a user in two rooms counts twice).",
+	})
+
+	// RacesByState is labeled by race state (open, in progress, finished,
+	// etc.); it is registered, but nothing in this tree sets it, so it
+	// exports no series
+	RacesByState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "isaac_races",
+		Help: "Number of races currently in each state.",
+	}, []string{"state"})
+
+	// CommandsProcessed counts every WebSocket command handled, by command name
+	CommandsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "isaac_commands_processed_total",
+		Help: "Total number of WebSocket commands processed, by command name.",
+	}, []string{"command"})
+
+	// CommandLatency is a histogram of handler duration, by command name
+	CommandLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "isaac_command_latency_seconds",
+		Help:    "WebSocket command handler latency in seconds, by command name.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"command"})
+
+	// ConnectionsByProtocol tracks how many currently-connected clients
+	// negotiated each wire protocol (e.g. "json", "msgpack"), mirroring
+	// protocol.ConnectionCounts()
+	ConnectionsByProtocol = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "isaac_connections_by_protocol",
+		Help: "Number of currently-connected clients that negotiated each wire protocol.",
+	}, []string{"protocol"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		ConnectedClients,
+		ChatRooms,
+		ChatUsers,
+		RacesByState,
+		CommandsProcessed,
+		CommandLatency,
+		ConnectionsByProtocol,
+	)
+}
+
+// Handler returns the "/metrics" HTTP handler in Prometheus text exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
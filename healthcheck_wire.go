@@ -0,0 +1,148 @@
+package main
+
+/*
+	Imports
+*/
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Zamiell/isaac-racing-server/healthcheck"
+	"github.com/Zamiell/isaac-racing-server/protocol"
+)
+
+/*
+	Constants
+*/
+
+const (
+	shutdownTimeout     = 30 * time.Second
+	metricsPollInterval = 5 * time.Second
+)
+
+/*
+	Variables
+*/
+
+var healthChecker = healthcheck.New()
+
+// routerReady is set to true once router.Handler() has been wired up to
+// "/ws" in main(), so the "router" readiness check reports not-ready until
+// the server can actually accept WebSocket connections
+var routerReady bool
+
+var errRouterNotReady = errors.New("the Golem router is not wired up yet")
+
+// healthcheckInit registers the readiness checks and starts the background
+// goroutine that keeps the connection/room gauges current. It is called from
+// main() right before the HTTP routes are wired up.
+//
+// There is no "twitch" check here: twitchInit() lives outside this snapshot
+// and nothing in this tree ever observes whether it actually connected, so a
+// check against it would just be a check that always fails.
+func healthcheckInit() {
+	healthChecker.Register("database", func() error {
+		_, err := db.Races.GetCurrentRaces()
+		return err
+	})
+	healthChecker.Register("router", func() error {
+		if !routerReady {
+			return errRouterNotReady
+		}
+		return nil
+	})
+
+	go pollGauges()
+}
+
+func pollGauges() {
+	ticker := time.NewTicker(metricsPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		connectionMap.RLock()
+		healthcheck.ConnectedClients.Set(float64(len(connectionMap.m)))
+		connectionMap.RUnlock()
+
+		chatRoomMap.RLock()
+		rooms := len(chatRoomMap.m)
+		users := 0
+		for _, usersInRoom := range chatRoomMap.m {
+			users += len(usersInRoom)
+		}
+		chatRoomMap.RUnlock()
+		healthcheck.ChatRooms.Set(float64(rooms))
+		healthcheck.ChatUsers.Set(float64(users))
+
+		for name, count := range protocol.ConnectionCounts() {
+			healthcheck.ConnectionsByProtocol.WithLabelValues(string(name)).Set(float64(count))
+		}
+	}
+}
+
+// withMetrics wraps a command handler so every call increments the
+// per-command counter and records handler latency, for the "/metrics" endpoint
+func withMetrics(command string, handler func(*ExtendedConnection, string)) func(*ExtendedConnection, string) {
+	return func(c *ExtendedConnection, data string) {
+		start := time.Now()
+		handler(c, data)
+		healthcheck.CommandsProcessed.WithLabelValues(command).Inc()
+		healthcheck.CommandLatency.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	}
+}
+
+/*
+	Graceful shutdown
+
+	On SIGTERM, stop accepting new readiness traffic, drain open WebSocket
+	connections, finalize any in-progress races, and only then let
+	ListenAndServeTLS return.
+*/
+
+func gracefulShutdown(srv *http.Server) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM)
+	<-sigChan
+
+	log.Info("Received SIGTERM; starting a graceful shutdown.")
+	healthChecker.ShutdownStarted()
+
+	drainConnections()
+	finalizeInProgressRaces()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Error("Failed to shut down the HTTP server gracefully:", err)
+	}
+}
+
+func drainConnections() {
+	connectionMap.RLock()
+	defer connectionMap.RUnlock()
+
+	for _, c := range connectionMap.m {
+		c.Emit("serverShutdown", "The server is restarting; please reconnect in a moment.")
+		c.Close()
+	}
+}
+
+func finalizeInProgressRaces() {
+	races, err := db.Races.GetCurrentRaces()
+	if err != nil {
+		log.Error("Failed to get the in-progress races during shutdown:", err)
+		return
+	}
+
+	for _, race := range races {
+		if err := db.Races.ForceFinish(race.ID); err != nil {
+			log.Error("Failed to finalize race", race.ID, "during shutdown:", err)
+		}
+	}
+}
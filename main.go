@@ -5,6 +5,7 @@ package main // In Go, executable commands must always use package main
 */
 
 import (
+	"github.com/Zamiell/isaac-racing-server/healthcheck"
 	"github.com/Zamiell/isaac-racing-server/models"
 
 	"net/http" // For establishing an HTTP server
@@ -15,8 +16,6 @@ import (
 
 	"github.com/bmizerany/pat"       // For HTTP routing
 	"github.com/didip/tollbooth"     // For rate-limiting login requests
-	"github.com/gorilla/context"     // For cookie sessions (1/2)
-	"github.com/gorilla/sessions"    // For cookie sessions (2/2)
 	"github.com/joho/godotenv"       // For reading environment variables that contain secrets
 	"github.com/op/go-logging"       // For logging
 	"github.com/tdewolff/minify"     // For minification (1/3)
@@ -30,7 +29,6 @@ import (
 */
 
 const (
-	sessionName   = "isaac.sid"
 	domain        = "isaacracing.net"
 	auth0Domain   = "isaacserver.auth0.com"
 	useSSL        = true
@@ -48,7 +46,6 @@ var (
 	projectPath   = os.Getenv("GOPATH") + "/src/github.com/Zamiell/isaac-racing-server"
 	log           = logging.MustGetLogger("isaac")
 	db            *models.Models
-	sessionStore  *sessions.CookieStore
 	commandMutex  = &sync.Mutex{} // Used to prevent race conditions
 	roomManager   = golem.NewRoomManager()
 	pmManager     = golem.NewRoomManager()
@@ -65,30 +62,6 @@ var (
 	achievementMap map[int][]string
 )
 
-/*
-	No directory listing stuff from: https://marc.ttias.be/golang-nuts/2016-03/msg00888.php
-*/
-
-type justFilesFilesystem struct {
-	fs http.FileSystem
-}
-
-func (fs justFilesFilesystem) Open(name string) (http.File, error) {
-	f, err := fs.fs.Open(name)
-	if err != nil {
-		return nil, err
-	}
-	return neuteredReaddirFile{f}, nil
-}
-
-type neuteredReaddirFile struct {
-	http.File
-}
-
-func (f neuteredReaddirFile) Readdir(count int) ([]os.FileInfo, error) {
-	return nil, nil
-}
-
 /*
 	HTTP to HTTPS redirect
 */
@@ -121,26 +94,8 @@ func main() {
 		log.Fatal("Failed to load .env file:", err)
 	}
 
-	// Create a session store
-	sessionSecret := os.Getenv("SESSION_SECRET")
-	sessionStore = sessions.NewCookieStore([]byte(sessionSecret))
-	maxAge := 5 // 5 seconds
-	if useSSL == true {
-		sessionStore.Options = &sessions.Options{
-			Domain:   domain,
-			Path:     "/",
-			MaxAge:   maxAge,
-			Secure:   true, // Only send the cookie over HTTPS: https://www.owasp.org/index.php/Testing_for_cookies_attributes_(OTG-SESS-002)
-			HttpOnly: true, // Mitigate XSS attacks: https://www.owasp.org/index.php/HttpOnly
-		}
-	} else {
-		sessionStore.Options = &sessions.Options{
-			Domain:   domain,
-			Path:     "/",
-			MaxAge:   maxAge,
-			HttpOnly: true, // Mitigate XSS attacks: https://www.owasp.org/index.php/HttpOnly
-		}
-	}
+	// Set up the JWT + app-password + TOTP auth subsystem
+	authInit()
 
 	// Initialize the database model
 	if db, err = models.GetModels(projectPath + "/database.sqlite"); err != nil {
@@ -169,60 +124,85 @@ func main() {
 	// Initialize the achievements
 	achievementsInit()
 
+	// Load the plugin/actor automation rules, if configured
+	pluginsInit()
+
+	// Register the readiness checks and start polling the metrics gauges
+	healthcheckInit()
+
 	// Start the Twitch bot
 	go twitchInit()
 
+	// Start the Discord bridge
+	go discordInit()
+
 	// Create a WebSocket router using the Golem framework
 	router := golem.NewRouter()
 	router.SetConnectionExtension(NewExtendedConnection)
 	router.OnHandshake(validateSession)
-	router.OnConnect(connOpen)
-	router.OnClose(connClose)
+	router.OnConnect(withProtocolTracking(connOpen))
+	router.OnClose(withProtocolUntracking(connClose))
+	protocolInit(router)
 
 	/*
 		The websocket commands
 	*/
 
 	// Chat commands
-	router.On("roomJoin", roomJoin)
-	router.On("roomLeave", roomLeave)
-	router.On("roomMessage", roomMessage)
-	router.On("privateMessage", privateMessage)
-	router.On("roomListAll", roomListAll)
+	router.On("roomJoin", withMetrics("roomJoin", withPluginHook("roomJoin", roomJoin)))
+	router.On("roomLeave", withMetrics("roomLeave", withPluginHook("roomLeave", roomLeave)))
+	router.On("roomMessage", withMetrics("roomMessage", withPluginHook("roomMessage", roomMessage)))
+	router.On("privateMessage", withMetrics("privateMessage", withPluginHook("privateMessage", privateMessage)))
+	router.On("roomListAll", withMetrics("roomListAll", roomListAll))
 
 	// Race commands
-	router.On("raceCreate", raceCreate)
-	router.On("raceJoin", raceJoin)
-	router.On("raceLeave", raceLeave)
-	router.On("raceReady", raceReady)
-	router.On("raceUnready", raceUnready)
-	router.On("raceRuleset", raceRuleset)
-	router.On("raceFinish", raceFinish)
-	router.On("raceQuit", raceQuit)
-	router.On("raceComment", raceComment)
-	router.On("raceItem", raceItem)
-	router.On("raceFloor", raceFloor)
+	router.On("raceCreate", withMetrics("raceCreate", withPluginHook("raceCreate", raceCreate)))
+	router.On("raceJoin", withMetrics("raceJoin", withPluginHook("raceJoin", raceJoin)))
+	router.On("raceLeave", withMetrics("raceLeave", withPluginHook("raceLeave", raceLeave)))
+	router.On("raceReady", withMetrics("raceReady", raceReady))
+	router.On("raceUnready", withMetrics("raceUnready", raceUnready))
+	router.On("raceRuleset", withMetrics("raceRuleset", raceRuleset))
+	router.On("raceFinish", withMetrics("raceFinish", withPluginHook("raceFinish", withRatingsUpdate(raceFinish))))
+	router.On("raceQuit", withMetrics("raceQuit", raceQuit))
+	router.On("raceComment", withMetrics("raceComment", raceComment))
+	router.On("raceItem", withMetrics("raceItem", raceItem))
+	router.On("raceFloor", withMetrics("raceFloor", raceFloor))
+	router.On("raceMatchmake", withMetrics("raceMatchmake", raceMatchmake))
+
+	// Rating commands
+	router.On("ratingGet", withMetrics("ratingGet", ratingGet))
+	router.On("ratingLeaderboard", withMetrics("ratingLeaderboard", ratingLeaderboard))
+	router.On("ratingSeasonReset", withMetrics("ratingSeasonReset", requireFreshTOTP(ratingSeasonReset)))
 
 	// Profile commands
-	router.On("profileGet", profileGet)
-	router.On("profileSetUsername", profileSetUsername)
-	router.On("profileSetStream", profileSetStream)
-	router.On("profileSetTwitchBotEnabled", profileSetTwitchBotEnabled)
-	router.On("profileSetTwitchBotDelay", profileSetTwitchBotDelay)
-
-	// Admin commands
-	router.On("adminBan", adminBan)
-	router.On("adminUnban", adminUnban)
-	router.On("adminBanIP", adminBanIP)
-	router.On("adminUnbanIP", adminUnbanIP)
-	router.On("adminSquelch", adminSquelch)
-	router.On("adminUnsquelch", adminUnsquelch)
-	router.On("adminPromote", adminPromote)
-	router.On("adminDemote", adminDemote)
+	router.On("profileGet", withMetrics("profileGet", profileGet))
+	router.On("profileSetUsername", withMetrics("profileSetUsername", profileSetUsername))
+	router.On("profileSetStream", withMetrics("profileSetStream", profileSetStream))
+	router.On("profileSetTwitchBotEnabled", withMetrics("profileSetTwitchBotEnabled", profileSetTwitchBotEnabled))
+	router.On("profileSetTwitchBotDelay", withMetrics("profileSetTwitchBotDelay", profileSetTwitchBotDelay))
+
+	// Admin commands (all gated behind a fresh TOTP claim)
+	router.On("adminBan", withMetrics("adminBan", requireFreshTOTP(withPluginHook("adminBan", adminBan))))
+	router.On("adminUnban", withMetrics("adminUnban", requireFreshTOTP(adminUnban)))
+	router.On("adminBanIP", withMetrics("adminBanIP", requireFreshTOTP(adminBanIP)))
+	router.On("adminUnbanIP", withMetrics("adminUnbanIP", requireFreshTOTP(adminUnbanIP)))
+	router.On("adminSquelch", withMetrics("adminSquelch", requireFreshTOTP(withPluginHook("adminSquelch", adminSquelch))))
+	router.On("adminUnsquelch", withMetrics("adminUnsquelch", requireFreshTOTP(adminUnsquelch)))
+	router.On("adminPromote", withMetrics("adminPromote", requireFreshTOTP(adminPromote)))
+	router.On("adminDemote", withMetrics("adminDemote", requireFreshTOTP(adminDemote)))
+	router.On("adminDiscordLink", withMetrics("adminDiscordLink", requireFreshTOTP(adminDiscordLink)))
+	router.On("adminDiscordUnlink", withMetrics("adminDiscordUnlink", requireFreshTOTP(adminDiscordUnlink)))
+	router.On("adminReloadPlugins", withMetrics("adminReloadPlugins", requireFreshTOTP(adminReloadPlugins)))
 
 	// Miscellaneous
-	router.On("logout", logout)
-	router.On("debug", debug)
+	router.On("logout", withMetrics("logout", logout))
+	router.On("debug", withMetrics("debug", debug))
+
+	// Profile / account security commands
+	router.On("profileGenerateAppPassword", withMetrics("profileGenerateAppPassword", profileGenerateAppPassword))
+	router.On("profileRevokeAppPassword", withMetrics("profileRevokeAppPassword", profileRevokeAppPassword))
+	router.On("profileEnableTOTP", withMetrics("profileEnableTOTP", profileEnableTOTP))
+	router.On("profileDisableTOTP", withMetrics("profileDisableTOTP", profileDisableTOTP))
 
 	/*
 		HTTP stuff
@@ -254,14 +234,21 @@ func main() {
 	p.Get("/races", tollbooth.LimitFuncHandler(tollbooth.NewLimiter(1, time.Second), httpRaces))
 	p.Get("/profiles", tollbooth.LimitFuncHandler(tollbooth.NewLimiter(1, time.Second), httpProfiles))
 	p.Get("/leaderboards", tollbooth.LimitFuncHandler(tollbooth.NewLimiter(1, time.Second), httpLeaderboards))
+	p.Get("/leaderboards/trueskill", tollbooth.LimitFuncHandler(tollbooth.NewLimiter(1, time.Second), httpLeaderboardsTrueSkill))
 	p.Get("/info", tollbooth.LimitFuncHandler(tollbooth.NewLimiter(1, time.Second), httpInfo))
 	p.Get("/download", tollbooth.LimitFuncHandler(tollbooth.NewLimiter(1, time.Second), httpDownload))
 	p.Post("/login", tollbooth.LimitFuncHandler(tollbooth.NewLimiter(1, time.Second), loginHandler))
 
 	// Assign functions to URIs
 	http.Handle("/", p)
-	http.Handle("/public/", http.StripPrefix("/public/", http.FileServer(justFilesFilesystem{http.Dir("public")})))
+	http.Handle("/public/", http.StripPrefix("/public/", http.FileServer(publicFilesystem)))
 	http.HandleFunc("/ws", router.Handler())
+	routerReady = true
+
+	// Health, readiness, and metrics routes bypass the tollbooth rate limiter
+	http.HandleFunc("/healthz", healthChecker.Liveness)
+	http.HandleFunc("/readyz", healthChecker.Readiness)
+	http.Handle("/metrics", healthcheck.Handler())
 
 	/*
 		Start the server
@@ -277,22 +264,20 @@ func main() {
 	}
 
 	// Listen and serve
+	srv := &http.Server{
+		Addr:    ":" + strconv.Itoa(port), // Nothing before the colon implies 0.0.0.0
+		Handler: http.DefaultServeMux,
+	}
+	go gracefulShutdown(srv)
+
 	log.Info("Listening on port " + strconv.Itoa(port) + ".")
 	if useSSL == true {
-		if err := http.ListenAndServeTLS(
-			":"+strconv.Itoa(port), // Nothing before the colon implies 0.0.0.0
-			sslCertFile,
-			sslKeyFile,
-			context.ClearHandler(http.DefaultServeMux), // We wrap with context.ClearHandler or else we will leak memory: http://www.gorillatoolkit.org/pkg/sessions
-		); err != nil {
+		if err := srv.ListenAndServeTLS(sslCertFile, sslKeyFile); err != nil && err != http.ErrServerClosed {
 			log.Fatal("ListenAndServeTLS failed:", err)
 		}
 	} else {
 		// Listen and serve (HTTP)
-		if err := http.ListenAndServe(
-			":"+strconv.Itoa(port),                     // Nothing before the colon implies 0.0.0.0
-			context.ClearHandler(http.DefaultServeMux), // We wrap with context.ClearHandler or else we will leak memory: http://www.gorillatoolkit.org/pkg/sessions
-		); err != nil {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatal("ListenAndServeTLS failed:", err)
 		}
 	}
@@ -0,0 +1,79 @@
+package models
+
+/*
+	Imports
+*/
+
+import (
+	"database/sql"
+	"strings"
+)
+
+/*
+	AppPasswords is the model for the "app_passwords" table. An app password
+	is a named, long-lived token that a user generates for a third-party tool
+	(e.g. a race tracker) that should not need their full login session, and
+	that is scoped to only the WebSocket commands it actually needs.
+*/
+
+type AppPasswords struct {
+	db *sql.DB
+}
+
+// AppPassword represents one row of the "app_passwords" table
+type AppPassword struct {
+	UserID int
+	Name   string
+	Token  string
+	Scopes []string // WebSocket command names this token is allowed to call
+}
+
+// Create inserts a new app password with the caller-generated token
+func (a *AppPasswords) Create(userID int, name string, token string, scopes []string) error {
+	_, err := a.db.Exec(`
+		INSERT INTO app_passwords (user_id, name, token, scopes)
+		VALUES (?, ?, ?, ?)
+	`, userID, name, token, strings.Join(scopes, ","))
+	return err
+}
+
+// Revoke deletes an app password belonging to a user
+func (a *AppPasswords) Revoke(userID int, name string) error {
+	_, err := a.db.Exec(`
+		DELETE FROM app_passwords
+		WHERE user_id = ?
+			AND name = ?
+	`, userID, name)
+	return err
+}
+
+// GetByToken looks up an app password by its token, e.g. to authenticate an
+// incoming WebSocket connection from a third-party tool
+func (a *AppPasswords) GetByToken(token string) (AppPassword, error) {
+	var appPassword AppPassword
+	var scopes string
+
+	err := a.db.QueryRow(`
+		SELECT user_id, name, token, scopes
+		FROM app_passwords
+		WHERE token = ?
+	`, token).Scan(&appPassword.UserID, &appPassword.Name, &appPassword.Token, &scopes)
+	if err != nil {
+		return appPassword, err
+	}
+
+	if scopes != "" {
+		appPassword.Scopes = strings.Split(scopes, ",")
+	}
+	return appPassword, nil
+}
+
+// Allows reports whether this app password is scoped to call the given command
+func (p AppPassword) Allows(command string) bool {
+	for _, scope := range p.Scopes {
+		if scope == command {
+			return true
+		}
+	}
+	return false
+}
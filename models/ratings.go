@@ -0,0 +1,131 @@
+package models
+
+/*
+	Imports
+*/
+
+import (
+	"database/sql"
+)
+
+/*
+	Ratings is the model for the "ratings" table, which stores a TrueSkill
+	(mu, sigma) pair per user, per ruleset, per season. It is registered on
+	the Models struct in models.go in the same fashion as Races.
+*/
+
+type Ratings struct {
+	db *sql.DB
+}
+
+// Rating represents one row of the "ratings" table
+type Rating struct {
+	UserID  int
+	Ruleset string
+	Season  int
+	Mu      float64
+	Sigma   float64
+}
+
+// Get returns the rating for a user in a given ruleset/season, or the default
+// (unrated) values if the user has not yet finished a race in that ruleset/season
+func (r *Ratings) Get(userID int, ruleset string, season int) (Rating, error) {
+	var rating Rating
+	rating.UserID = userID
+	rating.Ruleset = ruleset
+	rating.Season = season
+
+	err := r.db.QueryRow(`
+		SELECT mu, sigma
+		FROM ratings
+		WHERE user_id = ?
+			AND ruleset = ?
+			AND season = ?
+	`, userID, ruleset, season).Scan(&rating.Mu, &rating.Sigma)
+	if err == sql.ErrNoRows {
+		rating.Mu = defaultMu
+		rating.Sigma = defaultSigma
+		return rating, nil
+	}
+	if err != nil {
+		return rating, err
+	}
+
+	return rating, nil
+}
+
+// Set upserts a user's rating for a given ruleset/season
+func (r *Ratings) Set(rating Rating) error {
+	_, err := r.db.Exec(`
+		INSERT INTO ratings (user_id, ruleset, season, mu, sigma)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			mu = VALUES(mu),
+			sigma = VALUES(sigma)
+	`, rating.UserID, rating.Ruleset, rating.Season, rating.Mu, rating.Sigma)
+	return err
+}
+
+// Leaderboard returns the top ratings for a ruleset/season, ordered by the
+// conservative rating estimate (mu - 3*sigma), descending
+func (r *Ratings) Leaderboard(ruleset string, season int, limit int) ([]Rating, error) {
+	rows, err := r.db.Query(`
+		SELECT user_id, mu, sigma
+		FROM ratings
+		WHERE ruleset = ?
+			AND season = ?
+		ORDER BY (mu - (3 * sigma)) DESC
+		LIMIT ?
+	`, ruleset, season, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ratings := make([]Rating, 0)
+	for rows.Next() {
+		rating := Rating{
+			Ruleset: ruleset,
+			Season:  season,
+		}
+		if err := rows.Scan(&rating.UserID, &rating.Mu, &rating.Sigma); err != nil {
+			return nil, err
+		}
+		ratings = append(ratings, rating)
+	}
+
+	return ratings, rows.Err()
+}
+
+// CurrentSeason returns the season number recorded by the most recent call to
+// AdvanceSeason, defaulting to season 1 if none has ever been recorded. This
+// is tracked in its own "seasons" table rather than derived from the ratings
+// table, since a season with no rated games yet would otherwise be
+// indistinguishable from one that never started.
+func (r *Ratings) CurrentSeason() (int, error) {
+	var season int
+	err := r.db.QueryRow(`SELECT COALESCE(MAX(season), 1) FROM seasons`).Scan(&season)
+	return season, err
+}
+
+// AdvanceSeason records the start of a new TrueSkill season and returns its
+// number. It is called by the "ratingSeasonReset" admin command; ratings from
+// the prior season remain queryable by passing its season number explicitly.
+func (r *Ratings) AdvanceSeason() (int, error) {
+	season, err := r.CurrentSeason()
+	if err != nil {
+		return 0, err
+	}
+
+	next := season + 1
+	if _, err := r.db.Exec(`INSERT INTO seasons (season) VALUES (?)`, next); err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+const (
+	// A new player starts with no information about their skill (high uncertainty)
+	defaultMu    = 25.0
+	defaultSigma = defaultMu / 3
+)
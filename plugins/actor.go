@@ -0,0 +1,175 @@
+package plugins
+
+/*
+	Imports
+*/
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+/*
+	Actor is implemented by every action that a rule can take when it fires.
+	Built-in actors are registered by name in newActor() below; operators
+	select one by setting "type" on a rule's action in the YAML/JSON config.
+*/
+
+type Actor interface {
+	Run(ctx *Context) error
+	Async() bool
+}
+
+// Context carries the event payload and the server-side callbacks an actor
+// needs down to the actor's Run() method
+type Context struct {
+	Event   string
+	Payload map[string]interface{}
+	Deps    Dependencies
+}
+
+// Dependencies are the server-side hooks that actors call into. They are
+// injected by main.go when the engine is created so that this package does
+// not need to import package main (which would create an import cycle).
+type Dependencies struct {
+	Ban         func(userID int, reason string) error
+	Squelch     func(userID int, seconds int) error
+	SendMessage func(room string, message string) error
+	DiscordPost func(channelID string, message string) error
+}
+
+func newActor(config ActionConfig) (Actor, error) {
+	switch config.Type {
+	case "ban":
+		return &banActor{config}, nil
+	case "squelch":
+		return &squelchActor{config}, nil
+	case "sendMessage":
+		return &sendMessageActor{config}, nil
+	case "webhookPost":
+		return &webhookPostActor{config}, nil
+	case "delay":
+		return &delayActor{config}, nil
+	case "discordPost":
+		return &discordPostActor{config}, nil
+	default:
+		return nil, errors.New("unknown actor type: " + config.Type)
+	}
+}
+
+func stringParam(config ActionConfig, key string) string {
+	if v, ok := config.Params[key]; ok {
+		if s, ok := v.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+func intParam(config ActionConfig, key string) int {
+	if v, ok := config.Params[key]; ok {
+		switch n := v.(type) {
+		case int:
+			return n
+		case float64:
+			return int(n)
+		}
+	}
+	return 0
+}
+
+// targetUserID resolves the user an actor should act on: the rule's static
+// config wins if set (so a rule can target a fixed user), otherwise it falls
+// back to the userID on the event payload (the user who triggered the
+// event), which is what lets a rule like "ban whoever sent this message"
+// work without hardcoding a userID in the rule file.
+func targetUserID(config ActionConfig, payload map[string]interface{}) int {
+	if _, ok := config.Params["userID"]; ok {
+		return intParam(config, "userID")
+	}
+
+	switch v := payload["userID"].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+/*
+	Built-in actors
+*/
+
+type banActor struct{ config ActionConfig }
+
+func (a *banActor) Async() bool { return a.config.Async }
+func (a *banActor) Run(ctx *Context) error {
+	userID := targetUserID(a.config, ctx.Payload)
+	reason := stringParam(a.config, "reason")
+	if reason == "" {
+		reason = "banned automatically by rule"
+	}
+	return ctx.Deps.Ban(userID, reason)
+}
+
+type squelchActor struct{ config ActionConfig }
+
+func (a *squelchActor) Async() bool { return a.config.Async }
+func (a *squelchActor) Run(ctx *Context) error {
+	userID := targetUserID(a.config, ctx.Payload)
+	seconds := intParam(a.config, "seconds")
+	return ctx.Deps.Squelch(userID, seconds)
+}
+
+type sendMessageActor struct{ config ActionConfig }
+
+func (a *sendMessageActor) Async() bool { return a.config.Async }
+func (a *sendMessageActor) Run(ctx *Context) error {
+	room := stringParam(a.config, "room")
+	message := stringParam(a.config, "message")
+	return ctx.Deps.SendMessage(room, message)
+}
+
+type discordPostActor struct{ config ActionConfig }
+
+func (a *discordPostActor) Async() bool { return a.config.Async }
+func (a *discordPostActor) Run(ctx *Context) error {
+	channelID := stringParam(a.config, "channelID")
+	message := stringParam(a.config, "message")
+	return ctx.Deps.DiscordPost(channelID, message)
+}
+
+type delayActor struct{ config ActionConfig }
+
+func (a *delayActor) Async() bool { return true } // a delay must never block the event dispatcher
+func (a *delayActor) Run(ctx *Context) error {
+	seconds := intParam(a.config, "seconds")
+	time.Sleep(time.Duration(seconds) * time.Second)
+	return nil
+}
+
+type webhookPostActor struct{ config ActionConfig }
+
+func (a *webhookPostActor) Async() bool { return a.config.Async }
+func (a *webhookPostActor) Run(ctx *Context) error {
+	url := stringParam(a.config, "url")
+	if url == "" {
+		return errors.New("webhookPost actor is missing a \"url\" param")
+	}
+
+	body := stringParam(a.config, "body")
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("webhookPost to \"%v\" returned status %v", url, resp.StatusCode)
+	}
+	return nil
+}
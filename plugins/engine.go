@@ -0,0 +1,216 @@
+package plugins
+
+/*
+	Imports
+*/
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+/*
+	Rule is one operator-authored automation: "on event X matching predicate Y,
+	run actions A, B, C". Rules are loaded from a YAML or JSON file (chosen by
+	file extension) and can be hot-reloaded via the "adminReloadPlugins"
+	WebSocket command without recompiling the server.
+*/
+
+type Rule struct {
+	Name     string         `yaml:"name" json:"name"`
+	Event    string         `yaml:"event" json:"event"`
+	If       string         `yaml:"if" json:"if"` // e.g. "message contains gg ez"; empty means "always match"
+	Actions  []ActionConfig `yaml:"actions" json:"actions"`
+	Cooldown int            `yaml:"cooldownSeconds" json:"cooldownSeconds"` // minimum seconds between firings of this rule
+}
+
+// ActionConfig describes one actor invocation within a rule
+type ActionConfig struct {
+	Type   string                 `yaml:"type" json:"type"`
+	Params map[string]interface{} `yaml:"params" json:"params"`
+	Async  bool                   `yaml:"async" json:"async"`
+}
+
+/*
+	Engine
+*/
+
+// Engine holds the currently-loaded rules and dispatches events to them. It
+// is safe for concurrent use, since WebSocket events arrive on many
+// goroutines at once.
+type Engine struct {
+	sync.RWMutex
+	rules     []Rule
+	deps      Dependencies
+	lastFired map[string]time.Time
+	path      string
+}
+
+// NewEngine creates a plugin engine wired up to the given server-side
+// callbacks. Call LoadRules() to load the initial rule set.
+func NewEngine(deps Dependencies) *Engine {
+	return &Engine{
+		deps:      deps,
+		lastFired: make(map[string]time.Time),
+	}
+}
+
+// LoadRules reads the rule file at path (YAML or JSON, chosen by extension)
+// and atomically replaces the engine's active rule set
+func (e *Engine) LoadRules(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var rules []Rule
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		err = yaml.Unmarshal(raw, &rules)
+	} else {
+		err = json.Unmarshal(raw, &rules)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	e.rules = rules
+	e.path = path
+	return nil
+}
+
+// Reload re-reads the rule file that was last passed to LoadRules(). It is
+// what the "adminReloadPlugins" command calls so that organizers can edit
+// the rule file and apply it without restarting the server.
+func (e *Engine) Reload() error {
+	e.RLock()
+	path := e.path
+	e.RUnlock()
+
+	if path == "" {
+		return nil
+	}
+	return e.LoadRules(path)
+}
+
+// Hook is called at each of the existing router.On(...) call sites (chat,
+// race, admin) in main.go with the event name and the decoded command
+// payload. Matching rules have their actions run, synchronously or in a new
+// goroutine depending on each action's "async" setting; the "delay" actor
+// always runs in a new goroutine, whatever its setting.
+func (e *Engine) Hook(event string, payload map[string]interface{}) {
+	e.RLock()
+	rules := e.rules
+	e.RUnlock()
+
+	for _, rule := range rules {
+		if rule.Event != event {
+			continue
+		}
+		if !matches(rule.If, payload) {
+			continue
+		}
+		if e.onCooldown(rule) {
+			continue
+		}
+
+		e.markFired(rule)
+		e.runActions(rule, event, payload)
+	}
+}
+
+func (e *Engine) onCooldown(rule Rule) bool {
+	if rule.Cooldown <= 0 {
+		return false
+	}
+
+	e.RLock()
+	last, ok := e.lastFired[rule.Name]
+	e.RUnlock()
+	if !ok {
+		return false
+	}
+
+	return time.Since(last) < time.Duration(rule.Cooldown)*time.Second
+}
+
+func (e *Engine) markFired(rule Rule) {
+	e.Lock()
+	defer e.Unlock()
+	e.lastFired[rule.Name] = time.Now()
+}
+
+func (e *Engine) runActions(rule Rule, event string, payload map[string]interface{}) {
+	for _, actionConfig := range rule.Actions {
+		actor, err := newActor(actionConfig)
+		if err != nil {
+			continue
+		}
+
+		ctx := &Context{Event: event, Payload: payload, Deps: e.deps}
+		if actor.Async() {
+			go actor.Run(ctx)
+		} else {
+			actor.Run(ctx)
+		}
+	}
+}
+
+/*
+	Predicate matching
+
+	Rules intentionally use a small home-grown matcher instead of pulling in a
+	full expression-evaluation library: "<field> <op> <value>" where op is one
+	of "==", "!=", or "contains". An empty predicate always matches.
+*/
+
+func matches(predicate string, payload map[string]interface{}) bool {
+	predicate = strings.TrimSpace(predicate)
+	if predicate == "" {
+		return true
+	}
+
+	for _, op := range []string{"==", "!=", "contains"} {
+		parts := strings.SplitN(predicate, " "+op+" ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		field := strings.TrimSpace(parts[0])
+		want := strings.Trim(strings.TrimSpace(parts[1]), `"`)
+		got := stringify(payload[field])
+
+		switch op {
+		case "==":
+			return got == want
+		case "!=":
+			return got != want
+		case "contains":
+			return strings.Contains(got, want)
+		}
+	}
+
+	// A predicate that does not parse never matches, rather than firing unexpectedly
+	return false
+}
+
+func stringify(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(raw)
+}
@@ -0,0 +1,86 @@
+package main
+
+/*
+	Imports
+*/
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/Zamiell/isaac-racing-server/plugins"
+)
+
+/*
+	This file wires the "plugins" package (operator-authored automation rules)
+	into the rest of the server. The engine itself knows nothing about golem,
+	chat rooms, or races; it is only given a small set of callbacks to invoke.
+*/
+
+var pluginEngine = plugins.NewEngine(plugins.Dependencies{
+	Ban: func(userID int, reason string) error {
+		return db.Users.Ban(userID, reason)
+	},
+	Squelch: func(userID int, seconds int) error {
+		return db.Users.Squelch(userID, seconds)
+	},
+	SendMessage: func(room string, message string) error {
+		return roomManager.Broadcast(room, "roomMessage", map[string]interface{}{
+			"room":    room,
+			"name":    "Server",
+			"message": message,
+		})
+	},
+	DiscordPost: func(channelID string, message string) error {
+		discordPost(channelID, message)
+		return nil
+	},
+})
+
+// pluginsInit loads the rule file pointed to by the PLUGIN_RULES_PATH
+// environment variable, if set. Like achievementsInit(), this runs once at
+// startup from main().
+func pluginsInit() {
+	path := os.Getenv("PLUGIN_RULES_PATH")
+	if path == "" {
+		log.Info("PLUGIN_RULES_PATH is blank in the .env file; no plugin rules will be loaded.")
+		return
+	}
+
+	if err := pluginEngine.LoadRules(path); err != nil {
+		log.Error("Failed to load the plugin rules from \""+path+"\":", err)
+		return
+	}
+
+	log.Info("Loaded the plugin rules from \"" + path + "\".")
+}
+
+// withPluginHook wraps an existing command handler so that, after the
+// handler runs, the plugin engine gets a chance to react to the same event.
+// It is used at the existing router.On(...) call sites for chat, race, and
+// admin commands in main.go. The command's own JSON fields (e.g. "message",
+// "room") are decoded into the payload so that rule predicates like
+// "message contains gg ez" can actually match against them.
+func withPluginHook(event string, handler func(*ExtendedConnection, string)) func(*ExtendedConnection, string) {
+	return func(c *ExtendedConnection, data string) {
+		handler(c, data)
+
+		payload := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(data), &payload); err != nil {
+			log.Error("Failed to unmarshal the data from a \""+event+"\" command for the plugin engine:", err)
+		}
+		payload["userID"] = c.UserID
+
+		pluginEngine.Hook(event, payload)
+	}
+}
+
+// adminReloadPlugins hot-reloads the rule file without restarting the server
+func adminReloadPlugins(c *ExtendedConnection, data string) {
+	if err := pluginEngine.Reload(); err != nil {
+		log.Error("Failed to reload the plugin rules:", err)
+		return
+	}
+
+	log.Info("Admin reloaded the plugin rules.")
+}
@@ -0,0 +1,68 @@
+package protocol
+
+/*
+	Imports
+*/
+
+import "testing"
+
+// raceItemUpdate mirrors the high-frequency payload that the Isaac client mod
+// sends during a race, used here to benchmark JSON against the binary protocol
+type raceItemUpdate struct {
+	RaceID  int `json:"raceID" msgpack:"raceID"`
+	UserID  int `json:"userID" msgpack:"userID"`
+	ItemID  int `json:"itemID" msgpack:"itemID"`
+	FloorID int `json:"floorID" msgpack:"floorID"`
+}
+
+var benchPayload = raceItemUpdate{RaceID: 1, UserID: 2, ItemID: 345, FloorID: 6}
+
+func BenchmarkJSONMarshal(b *testing.B) {
+	p := NewJSON()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Marshal(benchPayload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBinaryMarshal(b *testing.B) {
+	p := NewBinary()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Marshal(benchPayload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkJSONUnmarshal(b *testing.B) {
+	p := NewJSON()
+	data, err := p.Marshal(benchPayload)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var out raceItemUpdate
+		if err := p.Unmarshal(data, &out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBinaryUnmarshal(b *testing.B) {
+	p := NewBinary()
+	data, err := p.Marshal(benchPayload)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var out raceItemUpdate
+		if err := p.Unmarshal(data, &out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
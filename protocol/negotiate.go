@@ -0,0 +1,73 @@
+package protocol
+
+/*
+	Imports
+*/
+
+import (
+	"net/http"
+	"sync"
+)
+
+// QueryParam is the "/ws" query parameter used to request a non-default protocol,
+// e.g. "/ws?proto=msgpack"
+const QueryParam = "proto"
+
+// Negotiate picks a Protocol for an incoming "/ws" connection, checking the
+// "?proto=" query parameter first and falling back to the
+// "Sec-WebSocket-Protocol" header. withProtocolTracking calls this when a
+// connection opens and records only the returned protocol's name for the
+// per-protocol gauge; the frames on that connection use the router-wide
+// protocol, not the one returned here.
+func Negotiate(r *http.Request) Protocol {
+	switch Name(r.URL.Query().Get(QueryParam)) {
+	case Binary:
+		return NewBinary()
+	}
+
+	switch Name(r.Header.Get("Sec-WebSocket-Protocol")) {
+	case Binary:
+		return NewBinary()
+	}
+
+	return NewJSON()
+}
+
+/*
+	Per-connection metric: how many currently-connected clients chose each protocol
+*/
+
+var connectionCounts = struct {
+	sync.RWMutex
+	counts map[Name]int
+}{
+	counts: make(map[Name]int),
+}
+
+// RecordConnect increments the gauge for a protocol when a connection opens
+func RecordConnect(name Name) {
+	connectionCounts.Lock()
+	defer connectionCounts.Unlock()
+	connectionCounts.counts[name]++
+}
+
+// RecordDisconnect decrements the gauge for a protocol when a connection closes
+func RecordDisconnect(name Name) {
+	connectionCounts.Lock()
+	defer connectionCounts.Unlock()
+	if connectionCounts.counts[name] > 0 {
+		connectionCounts.counts[name]--
+	}
+}
+
+// ConnectionCounts returns a snapshot of connected-client count per protocol
+func ConnectionCounts() map[Name]int {
+	connectionCounts.RLock()
+	defer connectionCounts.RUnlock()
+
+	out := make(map[Name]int, len(connectionCounts.counts))
+	for name, count := range connectionCounts.counts {
+		out[name] = count
+	}
+	return out
+}
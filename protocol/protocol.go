@@ -0,0 +1,69 @@
+// Package protocol implements the wire formats that connections to "/ws" can
+// be framed in. JSON remains the default; BinaryProtocol trades readability
+// for bandwidth, which matters for the high-frequency "raceItem"/"raceFloor"
+// updates that the Isaac client mod sends during a race.
+package protocol
+
+/*
+	Imports
+*/
+
+import (
+	"encoding/json"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// Name identifies a protocol in the schema registry, metrics, and negotiation
+type Name string
+
+const (
+	// JSON is the format the server has always used
+	JSON Name = "json"
+
+	// Binary is a compact MessagePack framing. Clients can ask for it via
+	// "?proto=" or the "Sec-WebSocket-Protocol" header (see Negotiate), but
+	// the framing in use is chosen for the whole router, not per connection
+	Binary Name = "msgpack"
+)
+
+// Protocol marshals and unmarshals command payloads for one wire format
+type Protocol interface {
+	Name() Name
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonProtocol struct{}
+
+// NewJSON returns the default, human-readable protocol
+func NewJSON() Protocol {
+	return jsonProtocol{}
+}
+
+func (jsonProtocol) Name() Name { return JSON }
+
+func (jsonProtocol) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonProtocol) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type binaryProtocol struct{}
+
+// NewBinary returns the compact MessagePack protocol
+func NewBinary() Protocol {
+	return binaryProtocol{}
+}
+
+func (binaryProtocol) Name() Name { return Binary }
+
+func (binaryProtocol) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (binaryProtocol) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
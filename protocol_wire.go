@@ -0,0 +1,81 @@
+package main
+
+/*
+	Imports
+*/
+
+import (
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/Zamiell/isaac-racing-server/protocol"
+	"github.com/trevex/golem"
+)
+
+/*
+	This file wires the binary protocol into the rest of the server.
+
+	golem installs a single active protocol.Protocol for the whole Router, not
+	one per connection, so "WS_PROTOCOL=msgpack" in the .env file switches
+	every connection over to MessagePack framing (see protocolInit(), called
+	from main() before the router is wired up). withProtocolTracking /
+	withProtocolUntracking wrap connOpen / connClose below so that the
+	"?proto=" / "Sec-WebSocket-Protocol" a client actually asked for is still
+	recorded via protocol.RecordConnect / protocol.RecordDisconnect, which
+	lets us watch client adoption before flipping the server-wide switch.
+*/
+
+/*
+	Server-wide wire protocol
+*/
+
+// protocolInit switches every WebSocket connection over to the MessagePack
+// framing when WS_PROTOCOL is set to "msgpack" in the .env file; otherwise
+// the router keeps golem's default JSON framing. It must be called before
+// router.Handler() starts accepting connections.
+func protocolInit(router *golem.Router) {
+	if protocol.Name(os.Getenv("WS_PROTOCOL")) == protocol.Binary {
+		router.SetProtocol(protocol.NewGolemBinaryAdapter())
+		log.Info("Using the MessagePack wire protocol for all WebSocket connections.")
+	}
+}
+
+// connectionProtocols tracks which protocol.Name each currently-connected
+// client asked for via withProtocolTracking, so withProtocolUntracking can
+// look it up again to decrement the gauge when the connection closes.
+var connectionProtocols = struct {
+	sync.RWMutex
+	m map[*ExtendedConnection]protocol.Name
+}{m: make(map[*ExtendedConnection]protocol.Name)}
+
+// withProtocolTracking wraps connOpen so that the protocol a client requested
+// is recorded against the connected-clients-per-protocol gauge
+func withProtocolTracking(handler func(*ExtendedConnection, *http.Request)) func(*ExtendedConnection, *http.Request) {
+	return func(c *ExtendedConnection, r *http.Request) {
+		name := protocol.Negotiate(r).Name()
+
+		connectionProtocols.Lock()
+		connectionProtocols.m[c] = name
+		connectionProtocols.Unlock()
+
+		protocol.RecordConnect(name)
+		handler(c, r)
+	}
+}
+
+// withProtocolUntracking wraps connClose so that the gauge incremented by
+// withProtocolTracking is decremented again
+func withProtocolUntracking(handler func(*ExtendedConnection)) func(*ExtendedConnection) {
+	return func(c *ExtendedConnection) {
+		connectionProtocols.Lock()
+		name, ok := connectionProtocols.m[c]
+		delete(connectionProtocols.m, c)
+		connectionProtocols.Unlock()
+
+		if ok {
+			protocol.RecordDisconnect(name)
+		}
+		handler(c)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+/*
+	Imports
+*/
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/mafredri/go-trueskill"
+
+	"github.com/Zamiell/isaac-racing-server/models"
+)
+
+/*
+	Constants
+*/
+
+const (
+	// Used to group newcomers with similarly-rated players instead of veterans;
+	// can be overridden with the MATCHMAKE_RATING_WINDOW environment variable
+	defaultMatchmakeRatingWindow = 5.0
+)
+
+/*
+	raceFinish hook
+*/
+
+// RaceFinisher describes one participant's result in a just-finished race, in
+// finishing order. This is called from the "raceFinish" command handler once
+// the race has been marked as finished, so that TrueSkill ratings are kept up
+// to date for every ruleset the server supports.
+type RaceFinisher struct {
+	UserID int
+	Place  int // 1-indexed; ties share a place
+}
+
+func ratingsUpdateForRace(ruleset string, season int, finishers []RaceFinisher) {
+	if len(finishers) < 2 {
+		// TrueSkill needs at least two participants to produce a meaningful update
+		return
+	}
+
+	// go-trueskill has no rank/place parameter: AdjustSkillsWithDraws infers
+	// standing purely from slice order, with "players[i] and players[i+1] tied"
+	// expressed via the draws slice. So finishers must be sorted by place
+	// before we build the players slice.
+	sorted := make([]RaceFinisher, len(finishers))
+	copy(sorted, finishers)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Place < sorted[j].Place
+	})
+
+	ratings := make([]models.Rating, len(sorted))
+	players := make([]trueskill.Player, len(sorted))
+	for i, finisher := range sorted {
+		rating, err := db.Ratings.Get(finisher.UserID, ruleset, season)
+		if err != nil {
+			log.Error("Failed to get the rating for user "+strconv.Itoa(finisher.UserID)+":", err)
+			return
+		}
+		ratings[i] = rating
+		players[i] = trueskill.NewPlayer(rating.Mu, rating.Sigma)
+	}
+
+	draws := make([]bool, len(sorted)-1)
+	for i := range draws {
+		draws[i] = sorted[i].Place == sorted[i+1].Place
+	}
+
+	ts := trueskill.New()
+	adjusted, _ := ts.AdjustSkillsWithDraws(players, draws)
+
+	for i, player := range adjusted {
+		ratings[i].Mu = player.Mu()
+		ratings[i].Sigma = player.Sigma()
+		if err := db.Ratings.Set(ratings[i]); err != nil {
+			log.Error("Failed to save the rating for user "+strconv.Itoa(ratings[i].UserID)+":", err)
+		}
+	}
+}
+
+// withRatingsUpdate wraps the "raceFinish" command handler so that, once a
+// race is marked finished, every participant's TrueSkill rating is updated
+// for the race's ruleset and the current season. This is the only caller of
+// ratingsUpdateForRace.
+func withRatingsUpdate(handler func(*ExtendedConnection, string)) func(*ExtendedConnection, string) {
+	return func(c *ExtendedConnection, data string) {
+		handler(c, data)
+
+		var d struct {
+			RaceID int `json:"raceID"`
+		}
+		if err := json.Unmarshal([]byte(data), &d); err != nil {
+			log.Error("Failed to unmarshal the data from a \"raceFinish\" command:", err)
+			return
+		}
+
+		race, err := db.Races.Get(d.RaceID)
+		if err != nil {
+			log.Error("Failed to get race "+strconv.Itoa(d.RaceID)+" to update ratings:", err)
+			return
+		}
+
+		season, err := db.Ratings.CurrentSeason()
+		if err != nil {
+			log.Error("Failed to get the current season:", err)
+			return
+		}
+
+		// racer.Place is 0 for anyone who quit or never finished; only actual
+		// finishers count towards the TrueSkill update.
+		finishers := make([]RaceFinisher, 0, len(race.Racers))
+		for _, racer := range race.Racers {
+			if racer.Place <= 0 {
+				continue
+			}
+			finishers = append(finishers, RaceFinisher{UserID: racer.UserID, Place: racer.Place})
+		}
+		ratingsUpdateForRace(race.Ruleset, season, finishers)
+	}
+}
+
+/*
+	WebSocket commands
+*/
+
+// ratingGet returns the caller's own TrueSkill rating for a ruleset in the
+// current season, e.g. {"ruleset": "unseeded"}
+func ratingGet(c *ExtendedConnection, data string) {
+	var d struct {
+		Ruleset string `json:"ruleset"`
+	}
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		log.Error("Failed to unmarshal the data from a \"ratingGet\" command:", err)
+		return
+	}
+
+	season, err := db.Ratings.CurrentSeason()
+	if err != nil {
+		log.Error("Failed to get the current season:", err)
+		return
+	}
+
+	rating, err := db.Ratings.Get(c.UserID, d.Ruleset, season)
+	if err != nil {
+		log.Error("Failed to get the rating for user "+strconv.Itoa(c.UserID)+":", err)
+		return
+	}
+
+	c.Emit("ratingGet", rating)
+}
+
+// ratingLeaderboard returns the top N conservatively-rated players for a ruleset
+func ratingLeaderboard(c *ExtendedConnection, data string) {
+	var d struct {
+		Ruleset string `json:"ruleset"`
+		Limit   int    `json:"limit"`
+	}
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		log.Error("Failed to unmarshal the data from a \"ratingLeaderboard\" command:", err)
+		return
+	}
+	if d.Limit <= 0 {
+		d.Limit = 25
+	}
+
+	season, err := db.Ratings.CurrentSeason()
+	if err != nil {
+		log.Error("Failed to get the current season:", err)
+		return
+	}
+
+	leaderboard, err := db.Ratings.Leaderboard(d.Ruleset, season, d.Limit)
+	if err != nil {
+		log.Error("Failed to get the \""+d.Ruleset+"\" leaderboard:", err)
+		return
+	}
+
+	c.Emit("ratingLeaderboard", leaderboard)
+}
+
+// ratingSeasonReset is an admin command (gated behind requireFreshTOTP in
+// main.go) that advances to a new season, after which all ratings start
+// fresh (the prior season's ratings remain queryable by season number)
+func ratingSeasonReset(c *ExtendedConnection, data string) {
+	season, err := db.Ratings.AdvanceSeason()
+	if err != nil {
+		log.Error("Failed to advance the TrueSkill season:", err)
+		return
+	}
+
+	log.Info("Admin started a new TrueSkill season: " + strconv.Itoa(season))
+	c.Emit("ratingSeasonReset", map[string]interface{}{
+		"season": season,
+	})
+}
+
+// raceMatchmake opens or joins a hidden race grouped by conservative rating
+// (mu - 3*sigma) so that newcomers are not dropped into races with veterans
+func raceMatchmake(c *ExtendedConnection, data string) {
+	var d struct {
+		Ruleset string `json:"ruleset"`
+	}
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		log.Error("Failed to unmarshal the data from a \"raceMatchmake\" command:", err)
+		return
+	}
+
+	window := defaultMatchmakeRatingWindow
+	if envWindow := os.Getenv("MATCHMAKE_RATING_WINDOW"); envWindow != "" {
+		if parsed, err := strconv.ParseFloat(envWindow, 64); err == nil {
+			window = parsed
+		}
+	}
+
+	season, err := db.Ratings.CurrentSeason()
+	if err != nil {
+		log.Error("Failed to get the current season:", err)
+		return
+	}
+
+	rating, err := db.Ratings.Get(c.UserID, d.Ruleset, season)
+	if err != nil {
+		log.Error("Failed to get the rating for user "+strconv.Itoa(c.UserID)+":", err)
+		return
+	}
+	conservative := rating.Mu - (3 * rating.Sigma)
+
+	raceID := matchmakingQueue.findOrCreateRace(d.Ruleset, c.UserID, conservative, window)
+	c.Emit("raceMatchmake", map[string]interface{}{
+		"raceID": raceID,
+	})
+}
+
+/*
+	HTTP
+*/
+
+func httpLeaderboardsTrueSkill(w http.ResponseWriter, r *http.Request) {
+	ruleset := r.URL.Query().Get("ruleset")
+	if ruleset == "" {
+		ruleset = "unseeded"
+	}
+
+	season, err := db.Ratings.CurrentSeason()
+	if err != nil {
+		log.Error("Failed to get the current season:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	leaderboard, err := db.Ratings.Leaderboard(ruleset, season, 100)
+	if err != nil {
+		log.Error("Failed to get the \""+ruleset+"\" leaderboard:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	sort.Slice(leaderboard, func(i, j int) bool {
+		return (leaderboard[i].Mu - 3*leaderboard[i].Sigma) > (leaderboard[j].Mu - 3*leaderboard[j].Sigma)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(leaderboard); err != nil {
+		log.Error("Failed to encode the TrueSkill leaderboard:", err)
+	}
+}
+
+/*
+	Matchmaking queue
+*/
+
+// matchmakingQueue groups waiting players by ruleset so that raceMatchmake can
+// pair up participants within the configured rating window
+var matchmakingQueue = newMatchmaker()
+
+type waitingPlayer struct {
+	userID       int
+	conservative float64
+}
+
+type matchmaker struct {
+	commandMutex *sync.Mutex
+	waiting      map[string][]waitingPlayer
+}
+
+func newMatchmaker() *matchmaker {
+	return &matchmaker{
+		commandMutex: &sync.Mutex{},
+		waiting:      make(map[string][]waitingPlayer),
+	}
+}
+
+// findOrCreateRace pairs the caller with a waiting player of similar rating,
+// if any, creating a new hidden race for them and returning its ID; otherwise
+// it enqueues the caller and returns 0, meaning "waiting"
+func (m *matchmaker) findOrCreateRace(ruleset string, userID int, conservative float64, window float64) int {
+	m.commandMutex.Lock()
+	defer m.commandMutex.Unlock()
+
+	queue := m.waiting[ruleset]
+	for i, candidate := range queue {
+		if abs(candidate.conservative-conservative) <= window {
+			m.waiting[ruleset] = append(queue[:i], queue[i+1:]...)
+			raceID, err := db.Races.CreateHidden(ruleset, []int{candidate.userID, userID})
+			if err != nil {
+				log.Error("Failed to create a matchmade race:", err)
+				return 0
+			}
+			return raceID
+		}
+	}
+
+	m.waiting[ruleset] = append(queue, waitingPlayer{userID: userID, conservative: conservative})
+	return 0
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
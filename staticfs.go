@@ -0,0 +1,219 @@
+package main
+
+/*
+	Imports
+*/
+
+import (
+	"container/heap"
+	"io"
+	"net/http"
+	"os"
+	"path"
+)
+
+/*
+	orderedFilesystem serves "public/" in place of the old justFilesFilesystem
+	(see: https://marc.ttias.be/golang-nuts/2016-03/msg00888.php). It still
+	hides directory listings by default, but additionally:
+	  - enforces an extension whitelist, so arbitrary files under "public/"
+	    can't be served just because they happen to be there
+	  - for directories that explicitly opt in, serves a listing in
+	    alphabetical order, built with a bounded-size heap merge over
+	    Readdir() batches; the heap stays bounded, but the listing itself is
+	    returned whole from a single Readdir() call
+*/
+
+const (
+	defaultReaddirBatchSize = 100
+	defaultSortWindowSize   = 500
+)
+
+// publicFilesystem is wired into the "/public/" route in main(). No
+// subdirectory opts into listing, so in practice this only adds the
+// extension whitelist on top of the old behavior; the listing support exists
+// for admin-configured download directories.
+var publicFilesystem = newOrderedFilesystem(
+	http.Dir("public"),
+	[]string{".css", ".js", ".png", ".jpg", ".gif", ".ico", ".woff", ".woff2", ".ttf", ".svg"},
+	nil,
+)
+
+type orderedFilesystem struct {
+	fs                http.FileSystem
+	allowedExtensions map[string]bool // e.g. {".css": true}; empty means "allow any extension"
+	listableDirs      map[string]bool // directories (relative to the filesystem root) that serve a sorted index
+	batchSize         int
+	windowSize        int
+}
+
+// newOrderedFilesystem wraps fs. An empty allowedExtensions allows every
+// extension through unchanged (matching the old justFilesFilesystem
+// behavior); listableDirs are given as slash-separated paths relative to fs's root.
+func newOrderedFilesystem(fs http.FileSystem, allowedExtensions []string, listableDirs []string) *orderedFilesystem {
+	allowed := make(map[string]bool, len(allowedExtensions))
+	for _, ext := range allowedExtensions {
+		allowed[ext] = true
+	}
+
+	listable := make(map[string]bool, len(listableDirs))
+	for _, dir := range listableDirs {
+		listable[path.Clean("/"+dir)] = true
+	}
+
+	return &orderedFilesystem{
+		fs:                fs,
+		allowedExtensions: allowed,
+		listableDirs:      listable,
+		batchSize:         defaultReaddirBatchSize,
+		windowSize:        defaultSortWindowSize,
+	}
+}
+
+func (ofs *orderedFilesystem) Open(name string) (http.File, error) {
+	if ofs.isDisallowedExtension(name) {
+		return nil, os.ErrNotExist
+	}
+
+	f, err := ofs.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if ofs.listableDirs[path.Clean(name)] {
+		return &sortedListingFile{File: f, batchSize: ofs.batchSize, windowSize: ofs.windowSize}, nil
+	}
+	return neuteredReaddirFile{f}, nil
+}
+
+func (ofs *orderedFilesystem) isDisallowedExtension(name string) bool {
+	if len(ofs.allowedExtensions) == 0 {
+		return false
+	}
+
+	ext := path.Ext(name)
+	if ext == "" {
+		// An extension-less path is almost always a directory; let Open() decide
+		return false
+	}
+	return !ofs.allowedExtensions[ext]
+}
+
+/*
+	Directory listings stay hidden unless explicitly enabled
+*/
+
+type neuteredReaddirFile struct {
+	http.File
+}
+
+func (f neuteredReaddirFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, nil
+}
+
+/*
+	Streaming alphabetical directory listing
+*/
+
+type sortedListingFile struct {
+	http.File
+	batchSize  int
+	windowSize int
+}
+
+func (f *sortedListingFile) Readdir(count int) ([]os.FileInfo, error) {
+	return windowedSortReaddir(f.File, f.batchSize, f.windowSize)
+}
+
+// windowedSortReaddir reads a directory in batches of batchSize and emits its
+// entries in alphabetical order using a bounded min-heap of at most
+// windowSize entries (the "replacement selection" technique used by external
+// merge sorts), so the heap itself never holds more than windowSize entries
+// regardless of how many are in the directory; entries read ahead of that
+// are kept in a single pending Readdir batch rather than pushed onto the
+// heap, so the working set is bounded by windowSize + batchSize. The returned
+// slice still holds every entry of the directory, and sortedListingFile's
+// Readdir ignores its count argument and returns the whole listing at once.
+// The output is fully sorted as long as every entry is fewer than windowSize
+// positions away from its sorted position when read in Readdir() order; a
+// windowSize smaller than that still keeps the heap bounded, but the result
+// is not a perfectly sorted one.
+func windowedSortReaddir(f http.File, batchSize int, windowSize int) ([]os.FileInfo, error) {
+	h := &fileInfoHeap{}
+	heap.Init(h)
+
+	var pending []os.FileInfo
+	drained := false
+
+	fill := func() error {
+		for (!drained || len(pending) > 0) && h.Len() < windowSize {
+			if len(pending) == 0 {
+				batch, err := f.Readdir(batchSize)
+				if err == io.EOF || len(batch) == 0 {
+					drained = true
+				} else if err != nil {
+					return err
+				}
+				pending = batch
+				if len(pending) == 0 {
+					continue
+				}
+			}
+
+			room := windowSize - h.Len()
+			if room > len(pending) {
+				room = len(pending)
+			}
+			for _, info := range pending[:room] {
+				heap.Push(h, info)
+			}
+			pending = pending[room:]
+		}
+		return nil
+	}
+
+	if err := fill(); err != nil {
+		return nil, err
+	}
+	observeHeapLen(h.Len())
+
+	sorted := make([]os.FileInfo, 0, h.Len())
+	for h.Len() > 0 {
+		sorted = append(sorted, heap.Pop(h).(os.FileInfo))
+		if err := fill(); err != nil {
+			return nil, err
+		}
+		observeHeapLen(h.Len())
+	}
+
+	return sorted, nil
+}
+
+// heapLenObserver, when set, is called with the heap's length after every
+// fill(); staticfs_test.go uses it to verify windowedSortReaddir's
+// bounded-heap contract without changing its signature.
+var heapLenObserver func(int)
+
+func observeHeapLen(n int) {
+	if heapLenObserver != nil {
+		heapLenObserver(n)
+	}
+}
+
+type fileInfoHeap []os.FileInfo
+
+func (h fileInfoHeap) Len() int           { return len(h) }
+func (h fileInfoHeap) Less(i, j int) bool { return h[i].Name() < h[j].Name() }
+func (h fileInfoHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *fileInfoHeap) Push(x interface{}) {
+	*h = append(*h, x.(os.FileInfo))
+}
+
+func (h *fileInfoHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
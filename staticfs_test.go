@@ -0,0 +1,241 @@
+package main
+
+/*
+	Imports
+*/
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+/*
+	Test helpers
+*/
+
+type fakeFileInfo struct {
+	name string
+}
+
+func (fi fakeFileInfo) Name() string       { return fi.name }
+func (fi fakeFileInfo) Size() int64        { return 0 }
+func (fi fakeFileInfo) Mode() os.FileMode  { return 0 }
+func (fi fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fakeFileInfo) IsDir() bool        { return false }
+func (fi fakeFileInfo) Sys() interface{}   { return nil }
+
+// fakeDir implements http.File over an in-memory, pre-batched list of
+// entries, so that Readdir() can be exercised without touching disk.
+type fakeDir struct {
+	batches [][]os.FileInfo
+	next    int
+}
+
+func (d *fakeDir) Close() error                                 { return nil }
+func (d *fakeDir) Read(p []byte) (int, error)                   { return 0, io.EOF }
+func (d *fakeDir) Seek(offset int64, whence int) (int64, error) { return 0, nil }
+func (d *fakeDir) Stat() (os.FileInfo, error)                   { return fakeFileInfo{name: "."}, nil }
+
+func (d *fakeDir) Readdir(count int) ([]os.FileInfo, error) {
+	if d.next >= len(d.batches) {
+		return nil, io.EOF
+	}
+	batch := d.batches[d.next]
+	d.next++
+	if d.next >= len(d.batches) {
+		return batch, io.EOF
+	}
+	return batch, nil
+}
+
+// namesToBatches splits names into fixed-size batches, mimicking repeated
+// Readdir(batchSize) calls against a real directory
+func namesToBatches(names []string, batchSize int) [][]os.FileInfo {
+	batches := make([][]os.FileInfo, 0)
+	for len(names) > 0 {
+		n := batchSize
+		if n > len(names) {
+			n = len(names)
+		}
+		batch := make([]os.FileInfo, n)
+		for i, name := range names[:n] {
+			batch[i] = fakeFileInfo{name: name}
+		}
+		batches = append(batches, batch)
+		names = names[n:]
+	}
+	return batches
+}
+
+func infoNames(infos []os.FileInfo) []string {
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names
+}
+
+/*
+	Tests
+*/
+
+func TestWindowedSortReaddirAlreadySorted(t *testing.T) {
+	names := []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"}
+	dir := &fakeDir{batches: namesToBatches(names, 2)}
+
+	sorted, err := windowedSortReaddir(dir, 2, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := infoNames(sorted)
+	for i := range names {
+		if got[i] != names[i] {
+			t.Fatalf("expected %v, got %v", names, got)
+		}
+	}
+}
+
+// TestWindowedSortReaddirLargerThanWindow verifies correctness for a
+// directory larger than the window as long as every entry is fewer than
+// windowSize positions from its final sorted position, which is the
+// documented contract of windowedSortReaddir. batchSize is kept smaller than
+// windowSize so that fill() actually has to loop over more than one Readdir
+// batch to fill the window, instead of windowSize being satisfied by a
+// single oversized batch.
+func TestWindowedSortReaddirLargerThanWindow(t *testing.T) {
+	const total = 1000
+	const windowSize = 50
+	const batchSize = 20
+
+	names := make([]string, total)
+	for i := 0; i < total; i++ {
+		names[i] = zeroPad(i)
+	}
+
+	// Swap each adjacent pair so every entry moves at most 1 position away
+	// from sorted order -- well within the window.
+	disordered := make([]string, total)
+	copy(disordered, names)
+	for i := 0; i+1 < total; i += 2 {
+		disordered[i], disordered[i+1] = disordered[i+1], disordered[i]
+	}
+
+	dir := &fakeDir{batches: namesToBatches(disordered, batchSize)}
+
+	sorted, err := windowedSortReaddir(dir, batchSize, windowSize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sorted) != total {
+		t.Fatalf("expected %v entries, got %v", total, len(sorted))
+	}
+
+	got := infoNames(sorted)
+	if !sort.StringsAreSorted(got) {
+		t.Fatalf("expected output to be sorted, got %v", got)
+	}
+	for i := range names {
+		if got[i] != names[i] {
+			t.Fatalf("mismatch at index %v: expected %v, got %v", i, names[i], got[i])
+		}
+	}
+}
+
+// TestWindowedSortReaddirMemoryBound verifies that windowedSortReaddir's
+// internal heap never holds more than windowSize entries at once, even
+// mid-stream, using the heapLenObserver test hook; batchSize is larger than
+// windowSize so that a single Readdir() batch would overflow the heap if
+// fill() pushed it on wholesale instead of holding the rest back as pending.
+func TestWindowedSortReaddirMemoryBound(t *testing.T) {
+	const windowSize = 10
+	const batchSize = 100
+	names := make([]string, 500)
+	for i := range names {
+		names[i] = zeroPad(i)
+	}
+
+	maxLen := 0
+	heapLenObserver = func(n int) {
+		if n > maxLen {
+			maxLen = n
+		}
+	}
+	defer func() { heapLenObserver = nil }()
+
+	dir := &fakeDir{batches: namesToBatches(names, batchSize)}
+	if _, err := windowedSortReaddir(dir, batchSize, windowSize); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if maxLen > windowSize {
+		t.Fatalf("heap grew past the window size: %v", maxLen)
+	}
+}
+
+// TestWindowedSortReaddirDisplacementExceedsWindow proves the documented
+// boundary of the contract: when an entry is displaced further than
+// windowSize positions from its sorted slot, the result is no longer
+// guaranteed to be fully sorted, even though every entry still comes out
+// exactly once.
+func TestWindowedSortReaddirDisplacementExceedsWindow(t *testing.T) {
+	const total = 100
+	const windowSize = 5
+	const batchSize = 5
+
+	names := make([]string, total)
+	for i := range names {
+		names[i] = zeroPad(i)
+	}
+
+	// Move the first entry to far past the end -- a displacement of (total-1),
+	// well beyond windowSize -- so the window can never hold it alongside the
+	// entries it needs to be sorted against.
+	displaced := make([]string, total)
+	copy(displaced, names[1:])
+	displaced[total-1] = names[0]
+
+	dir := &fakeDir{batches: namesToBatches(displaced, batchSize)}
+	sorted, err := windowedSortReaddir(dir, batchSize, windowSize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(sorted) != total {
+		t.Fatalf("expected %v entries, got %v", total, len(sorted))
+	}
+	if sort.StringsAreSorted(infoNames(sorted)) {
+		t.Fatal("expected the result to NOT be fully sorted, since the displacement exceeds windowSize")
+	}
+}
+
+func zeroPad(i int) string {
+	digits := "0123456789"
+	out := make([]byte, 4)
+	for pos := 3; pos >= 0; pos-- {
+		out[pos] = digits[i%10]
+		i /= 10
+	}
+	return string(out) + ".txt"
+}
+
+func TestOrderedFilesystemExtensionWhitelist(t *testing.T) {
+	ofs := newOrderedFilesystem(http.Dir(t.TempDir()), []string{".css", ".js"}, nil)
+
+	if ofs.isDisallowedExtension("/main.css") {
+		t.Error("expected .css to be allowed")
+	}
+	if ofs.isDisallowedExtension("/main.js") {
+		t.Error("expected .js to be allowed")
+	}
+	if !ofs.isDisallowedExtension("/secrets.env") {
+		t.Error("expected .env to be disallowed")
+	}
+	if ofs.isDisallowedExtension("/subdir") {
+		t.Error("expected an extension-less path to be allowed through to Open()")
+	}
+}